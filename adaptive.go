@@ -0,0 +1,315 @@
+package ethutil
+
+import (
+	"math/big"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// growAfterSuccesses is how many consecutive successful fetches, counted
+// across all workers of a scan, are needed before the next allocation is
+// grown towards Max.
+const growAfterSuccesses = 5
+
+// AdaptiveRangeConfig controls how GetEventAdaptive sizes its eth_getLogs
+// block ranges in response to provider feedback, instead of relying on the
+// fixed maxQueryBlockSize.
+type AdaptiveRangeConfig struct {
+	Min, Max, Initial        int64
+	GrowFactor, ShrinkFactor float64
+	ErrorPatterns            []string
+}
+
+func defaultAdaptiveRangeConfig() AdaptiveRangeConfig {
+	return AdaptiveRangeConfig{
+		Min:          1,
+		Max:          maxQueryBlockSize,
+		Initial:      maxQueryBlockSize,
+		GrowFactor:   2,
+		ShrinkFactor: 0.5,
+		ErrorPatterns: []string{
+			"query returned more than",
+			"response size exceeded",
+			"block range too large",
+			"too many results",
+		},
+	}
+}
+
+func (cfg AdaptiveRangeConfig) withDefaults() AdaptiveRangeConfig {
+	defaults := defaultAdaptiveRangeConfig()
+	if cfg.Min <= 0 {
+		cfg.Min = defaults.Min
+	}
+	if cfg.Max <= 0 {
+		cfg.Max = defaults.Max
+	}
+	if cfg.Initial <= 0 {
+		cfg.Initial = defaults.Initial
+	}
+	if cfg.GrowFactor <= 1 {
+		cfg.GrowFactor = defaults.GrowFactor
+	}
+	if cfg.ShrinkFactor <= 0 || cfg.ShrinkFactor >= 1 {
+		cfg.ShrinkFactor = defaults.ShrinkFactor
+	}
+	if len(cfg.ErrorPatterns) == 0 {
+		cfg.ErrorPatterns = defaults.ErrorPatterns
+	}
+	return cfg
+}
+
+func (cfg AdaptiveRangeConfig) isRangeTooLarge(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, pattern := range cfg.ErrorPatterns {
+		if strings.Contains(msg, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetEventAdaptive behaves like GetEvent but sizes each worker's block range
+// dynamically: ranges shrink and get re-enqueued when a provider rejects them
+// as too large, and grow back towards cfg.Max after a run of clean fetches.
+// Ranges are claimed lazily, one per dispatcher as it frees up, so a run of
+// successes actually has a chance to grow currentRangeSize before the next
+// claim reads it.
+func (c *ethClient) GetEventAdaptive(timeout time.Duration, from int64, to int64, address []common.Address, topics [][]common.Hash, cfg AdaptiveRangeConfig) (stream *logsStream, err error) {
+	cfg = cfg.withDefaults()
+	info := newAdaptiveGlobalInfo(timeout, from, to, address, topics, cfg)
+	info.pool = c.pool
+	info.breaker = c.breaker
+
+	dispatchers := cap(info.workChan)
+	info.group.Add(dispatchers)
+	for i := 0; i < dispatchers; i++ {
+		go adaptiveDispatchLoop(info, c.client)
+	}
+	info.group.Wait()
+	ok := atomic.CompareAndSwapInt32((*int32)(&info.state), 0, 1)
+	if !ok {
+		return nil, errors.Wrap(info.err, "get event error")
+	}
+	logs := info.arrangeLogs()
+	finalizer(info)
+	stream = &logsStream{
+		logs:      logs,
+		client:    c,
+		m:         sync.Mutex{},
+		group:     sync.WaitGroup{},
+		workMutex: sync.Mutex{},
+	}
+	return stream, nil
+}
+
+func newAdaptiveGlobalInfo(timeout time.Duration, from int64, to int64, address []common.Address, topics [][]common.Hash, cfg AdaptiveRangeConfig) (g *globalInfo) {
+	//estimate here is only an initial sizing hint for queue/workChan; the real
+	//count is unknown upfront since splits can add more work than this estimate
+	estimate := (to-from)/cfg.Initial + 1
+	g = &globalInfo{
+		end:              to,
+		errTrigger:       sync.Once{},
+		mutex:            sync.Mutex{},
+		queueMutex:       sync.Mutex{},
+		address:          address,
+		topics:           topics,
+		offset:           from,
+		timeout:          timeout,
+		queue:            make([]*logsWork, 0, estimate),
+		group:            sync.WaitGroup{},
+		adaptiveEnabled:  true,
+		adaptive:         cfg,
+		nextBlock:        from,
+		currentRangeSize: cfg.Initial,
+	}
+	chanNumber := estimate
+	if chanNumber > maxWorkNumber {
+		chanNumber = maxWorkNumber
+	}
+	g.workChan = make(chan int8, chanNumber)
+	var i int64
+	for ; i < chanNumber; i++ {
+		g.workChan <- 1
+	}
+	return g
+}
+
+// newAdaptiveLogsWork claims the next unclaimed block range, sized to the
+// globalInfo's current adaptive range size, and appends it to the queue. It
+// returns nil once every block up to global.end has been claimed.
+func newAdaptiveLogsWork(global *globalInfo) (result *logsWork) {
+	global.queueMutex.Lock()
+	defer global.queueMutex.Unlock()
+	if global.nextBlock > global.end {
+		return nil
+	}
+	rangeSize := atomic.LoadInt64(&global.currentRangeSize)
+	from := global.nextBlock
+	to := from + rangeSize - 1
+	if to > global.end {
+		to = global.end
+	}
+	global.nextBlock = to + 1
+	result = &logsWork{
+		id:        int32(len(global.queue)),
+		done:      make(chan struct{}, 1),
+		shareInfo: global,
+		filter:    ethereum.FilterQuery{Topics: global.topics, Addresses: global.address, FromBlock: big.NewInt(from), ToBlock: big.NewInt(to)},
+	}
+	result.done <- struct{}{}
+	global.queue = append(global.queue, result)
+	return result
+}
+
+// adaptiveDispatchLoop is one of GetEventAdaptive's fixed dispatchers: it
+// claims the next unclaimed range only once it is free to fetch it, so
+// recordSuccess's growth of currentRangeSize actually feeds back into later
+// claims instead of every range being sized before any fetch completes.
+func adaptiveDispatchLoop(global *globalInfo, client *ethclient.Client) {
+	defer global.group.Done()
+	for {
+		if atomic.LoadInt32((*int32)(&global.state)) != 0 {
+			return
+		}
+		work := newAdaptiveLogsWork(global)
+		if work == nil {
+			return
+		}
+		<-global.workChan
+		work.adaptiveFetch(client)
+		global.workChan <- 0
+	}
+}
+
+// enqueueAdaptiveSplit appends a pre-split sub-range directly to the queue,
+// bypassing the nextBlock cursor since the range has already been claimed by
+// the work it was split from.
+func enqueueAdaptiveSplit(global *globalInfo, from int64, to int64) *logsWork {
+	global.queueMutex.Lock()
+	defer global.queueMutex.Unlock()
+	result := &logsWork{
+		id:        int32(len(global.queue)),
+		done:      make(chan struct{}, 1),
+		shareInfo: global,
+		filter:    ethereum.FilterQuery{Topics: global.topics, Addresses: global.address, FromBlock: big.NewInt(from), ToBlock: big.NewInt(to)},
+	}
+	result.done <- struct{}{}
+	global.queue = append(global.queue, result)
+	global.group.Add(1)
+	return result
+}
+
+// adaptiveHandler runs one of split's halves on its own goroutine, gated by
+// shareInfo.workChan same as the fixed dispatcher pool, since a
+// split happens beyond GetEventAdaptive's initial dispatcher count and needs
+// its own accounting in shareInfo.group.
+func (work *logsWork) adaptiveHandler(client *ethclient.Client) {
+	go func() {
+		<-work.shareInfo.workChan
+		defer func() {
+			work.shareInfo.workChan <- 0
+		}()
+		defer work.shareInfo.group.Done()
+		work.adaptiveFetch(client)
+	}()
+}
+
+// adaptiveFetch runs one FilterLogs attempt for work: it halves and
+// re-enqueues work's own range on a "range too large" error, fails the whole
+// scan on any other error, and otherwise records the range's logs and grows
+// the shared range size after a run of clean fetches. Callers are
+// responsible for shareInfo.workChan and shareInfo.group accounting around
+// the call.
+func (work *logsWork) adaptiveFetch(client *ethclient.Client) {
+	if atomic.LoadInt32((*int32)(&work.shareInfo.state)) != 0 {
+		return
+	}
+	var admittedBy breakerState
+	for {
+		var ok bool
+		if ok, admittedBy = work.shareInfo.breaker.Allow(); ok {
+			break
+		}
+		time.Sleep(breakerPollInterval)
+	}
+	logs, err := work.filterLogs(client)
+	work.shareInfo.breaker.Record(admittedBy, err == nil)
+	if err != nil {
+		if work.shareInfo.adaptive.isRangeTooLarge(err) {
+			work.split(client, err)
+			return
+		}
+		work.shareInfo.errTrigger.Do(func() {
+			work.shareInfo.mutex.Lock()
+			atomic.SwapInt32((*int32)(&work.shareInfo.state), 2)
+			work.shareInfo.err = errors.Wrap(err, "get event error")
+			work.shareInfo.mutex.Unlock()
+		})
+		return
+	}
+	work.returnValue = logs
+	atomic.StoreInt32(&work.completed, 1)
+	work.recordSuccess()
+}
+
+// split halves work's own range into two new logsWork entries and dispatches
+// them, shrinking the shared range size so later allocations start smaller too.
+func (work *logsWork) split(client *ethclient.Client, err error) {
+	global := work.shareInfo
+	from := work.filter.FromBlock.Int64()
+	to := work.filter.ToBlock.Int64()
+	atomic.StoreInt32(&global.consecutiveSuccess, 0)
+	if from >= to {
+		//can't split a single-block range any further; surface the original error
+		global.errTrigger.Do(func() {
+			global.mutex.Lock()
+			atomic.SwapInt32((*int32)(&global.state), 2)
+			global.err = errors.Wrap(err, "adaptive range: single block range rejected as too large")
+			global.mutex.Unlock()
+		})
+		return
+	}
+	mid := from + (to-from)/2
+	shrunk := int64(float64(atomic.LoadInt64(&global.currentRangeSize)) * global.adaptive.ShrinkFactor)
+	if shrunk < global.adaptive.Min {
+		shrunk = global.adaptive.Min
+	}
+	atomic.StoreInt64(&global.currentRangeSize, shrunk)
+
+	left := enqueueAdaptiveSplit(global, from, mid)
+	right := enqueueAdaptiveSplit(global, mid+1, to)
+	left.adaptiveHandler(client)
+	right.adaptiveHandler(client)
+}
+
+func (work *logsWork) recordSuccess() {
+	global := work.shareInfo
+	if atomic.AddInt32(&global.consecutiveSuccess, 1) < growAfterSuccesses {
+		return
+	}
+	atomic.StoreInt32(&global.consecutiveSuccess, 0)
+	for {
+		current := atomic.LoadInt64(&global.currentRangeSize)
+		grown := int64(float64(current) * global.adaptive.GrowFactor)
+		if grown > global.adaptive.Max {
+			grown = global.adaptive.Max
+		}
+		if grown <= current {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&global.currentRangeSize, current, grown) {
+			return
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package ethutil
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const defaultHeaderBatchSize = 200
+
+// GetEventOptions configures optional prefiltering for GetEventFiltered.
+// UseBloomPrefilter is only supported on a single-endpoint client: header
+// fetching needs a concrete *ethclient.Client, which a pool-backed client
+// doesn't expose.
+type GetEventOptions struct {
+	UseBloomPrefilter bool
+	HeaderBatchSize   int
+}
+
+func (o GetEventOptions) withDefaults() GetEventOptions {
+	if o.HeaderBatchSize <= 0 {
+		o.HeaderBatchSize = defaultHeaderBatchSize
+	}
+	return o
+}
+
+// blockSpan is a contiguous range of blocks whose headers all passed the
+// bloom prefilter.
+type blockSpan struct {
+	from, to int64
+}
+
+// mayContainLog conservatively tests whether bloom could contain a log
+// matching addresses/topics. Bloom filters never produce false negatives, so
+// a false result means the block is safe to skip.
+func mayContainLog(bloom types.Bloom, addresses []common.Address, topics [][]common.Hash) bool {
+	if len(addresses) > 0 {
+		matched := false
+		for _, addr := range addresses {
+			if types.BloomLookup(bloom, addr) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, position := range topics {
+		if len(position) == 0 {
+			continue
+		}
+		matched := false
+		for _, topic := range position {
+			if types.BloomLookup(bloom, topic) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchHeadersBatch fetches headers [from, to] in a single batched
+// eth_getBlockByNumber(fullTx=false) JSON-RPC call.
+func fetchHeadersBatch(ctx context.Context, client *ethclient.Client, from int64, to int64) ([]*types.Header, error) {
+	n := int(to-from) + 1
+	headers := make([]*types.Header, n)
+	elems := make([]rpc.BatchElem, n)
+	for i := 0; i < n; i++ {
+		headers[i] = new(types.Header)
+		elems[i] = rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{hexutil.EncodeBig(big.NewInt(from + int64(i))), false},
+			Result: headers[i],
+		}
+	}
+	if err := client.Client().BatchCallContext(ctx, elems); err != nil {
+		return nil, err
+	}
+	for i, elem := range elems {
+		if elem.Error != nil {
+			return nil, elem.Error
+		}
+		if headers[i].Number == nil {
+			return nil, fmt.Errorf("header for block %d not found", from+int64(i))
+		}
+	}
+	return headers, nil
+}
+
+// candidateSpans fetches work's range's headers in batches of
+// opts.HeaderBatchSize and collapses the blocks whose bloom passed
+// mayContainLog into the maximal contiguous sub-ranges. Each batch is gated
+// by work.shareInfo.breaker, same as an eth_getLogs call, since it's still an
+// RPC round trip against the same endpoint.
+func (work *logsWork) candidateSpans(client *ethclient.Client, opts GetEventOptions) ([]blockSpan, error) {
+	from := work.filter.FromBlock.Int64()
+	to := work.filter.ToBlock.Int64()
+	var spans []blockSpan
+	spanStart := int64(-1)
+	for batchStart := from; batchStart <= to; batchStart += int64(opts.HeaderBatchSize) {
+		batchEnd := batchStart + int64(opts.HeaderBatchSize) - 1
+		if batchEnd > to {
+			batchEnd = to
+		}
+		var admittedBy breakerState
+		for {
+			var ok bool
+			if ok, admittedBy = work.shareInfo.breaker.Allow(); ok {
+				break
+			}
+			time.Sleep(breakerPollInterval)
+		}
+		headers, err := fetchHeadersBatch(context.Background(), client, batchStart, batchEnd)
+		work.shareInfo.breaker.Record(admittedBy, err == nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "fetch headers")
+		}
+		for i, header := range headers {
+			n := batchStart + int64(i)
+			if mayContainLog(header.Bloom, work.shareInfo.address, work.shareInfo.topics) {
+				if spanStart == -1 {
+					spanStart = n
+				}
+				continue
+			}
+			if spanStart != -1 {
+				spans = append(spans, blockSpan{spanStart, n - 1})
+				spanStart = -1
+			}
+		}
+	}
+	if spanStart != -1 {
+		spans = append(spans, blockSpan{spanStart, to})
+	}
+	return spans, nil
+}
+
+// bloomHandler is the bloom-prefiltered counterpart of logsWork.handler: it
+// narrows work's range down to the candidate sub-ranges before paying for
+// any eth_getLogs call, and skips the call entirely when nothing candidate
+// remains.
+func (work *logsWork) bloomHandler(client *ethclient.Client, opts GetEventOptions) {
+	go func() {
+		<-work.shareInfo.workChan
+		defer func() { work.shareInfo.workChan <- 0 }()
+		defer work.shareInfo.group.Done()
+		if atomic.LoadInt32((*int32)(&work.shareInfo.state)) != 0 {
+			return
+		}
+		spans, err := work.candidateSpans(client, opts)
+		if err != nil {
+			work.shareInfo.errTrigger.Do(func() {
+				work.shareInfo.mutex.Lock()
+				atomic.SwapInt32((*int32)(&work.shareInfo.state), 2)
+				work.shareInfo.err = errors.Wrap(err, "bloom prefilter")
+				work.shareInfo.mutex.Unlock()
+			})
+			return
+		}
+		var logs []types.Log
+		for _, span := range spans {
+			filter := work.filter
+			filter.FromBlock = big.NewInt(span.from)
+			filter.ToBlock = big.NewInt(span.to)
+			var admittedBy breakerState
+			for {
+				var ok bool
+				if ok, admittedBy = work.shareInfo.breaker.Allow(); ok {
+					break
+				}
+				time.Sleep(breakerPollInterval)
+			}
+			spanLogs, err := work.filterLogsWithFilter(client, filter)
+			work.shareInfo.breaker.Record(admittedBy, err == nil)
+			if err != nil {
+				work.shareInfo.errTrigger.Do(func() {
+					work.shareInfo.mutex.Lock()
+					atomic.SwapInt32((*int32)(&work.shareInfo.state), 2)
+					work.shareInfo.err = errors.Wrap(err, "get event error")
+					work.shareInfo.mutex.Unlock()
+				})
+				return
+			}
+			logs = append(logs, spanLogs...)
+		}
+		work.returnValue = logs
+		atomic.StoreInt32(&work.completed, 1)
+	}()
+}
+
+// GetEventFiltered behaves like GetEvent but, when opts.UseBloomPrefilter is
+// set, first fetches each worker's headers in bulk and tests their
+// LogsBloom against the filter's addresses and topics before paying for an
+// eth_getLogs round trip. Ranges with no candidate block are skipped
+// entirely, and a worker's original range is narrowed down to the maximal
+// sub-ranges that do have at least one candidate block; for sparse
+// contracts this typically removes most eth_getLogs calls at the cost of
+// cheaper header fetches, and also sidesteps "too many results" errors on
+// hot ranges by narrowing them.
+func (c *ethClient) GetEventFiltered(timeout time.Duration, from int64, to int64, address []common.Address, topics [][]common.Hash, opts GetEventOptions) (stream *logsStream, err error) {
+	opts = opts.withDefaults()
+	if opts.UseBloomPrefilter && c.pool != nil {
+		return nil, errors.New("ethutil: UseBloomPrefilter requires a single-endpoint client, not one built with NewEthClientFromPool")
+	}
+	info := newGlobalInfo(timeout, from, to, address, topics)
+	info.pool = c.pool
+	info.breaker = c.breaker
+	var i int32 = 0
+	for ; i < info.workNumber; i++ {
+		work := newLogsWork(info)
+		if opts.UseBloomPrefilter {
+			work.bloomHandler(c.client, opts)
+		} else {
+			work.handler(c.client)
+		}
+	}
+	info.group.Wait()
+	ok := atomic.CompareAndSwapInt32((*int32)(&info.state), 0, 1)
+	if !ok {
+		return nil, errors.Wrap(info.err, "get event error")
+	}
+	logs := info.arrangeLogs()
+	finalizer(info)
+	stream = &logsStream{
+		logs:      logs,
+		client:    c,
+		m:         sync.Mutex{},
+		group:     sync.WaitGroup{},
+		workMutex: sync.Mutex{},
+	}
+	return stream, nil
+}
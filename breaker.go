@@ -0,0 +1,309 @@
+package ethutil
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// breakerState is the three state circuitBreaker moves through: Closed lets
+// requests through (subject to the rate limiter), Open blocks everything
+// until cfg.Cooldown elapses, HalfOpen lets a limited number of probes
+// through to decide whether to go back to Closed or Open.
+type breakerState int32
+
+const (
+	BreakerClosed breakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+// breakerPollInterval is how long a worker sleeps between Allow() checks
+// while the breaker is Open or rate-limiting it.
+const breakerPollInterval = 50 * time.Millisecond
+
+func (s breakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// RateLimit configures a token-bucket limiter: Burst tokens refill at RPS
+// tokens per second. The zero value means unlimited - callers must opt into
+// a cap explicitly, it is never imposed by default.
+type RateLimit struct {
+	RPS   float64
+	Burst int
+}
+
+// tokenBucket is a standard token-bucket rate limiter. A zero-value RateLimit
+// makes it unlimited: allow always succeeds and no tokens are tracked.
+type tokenBucket struct {
+	mutex      sync.Mutex
+	unlimited  bool
+	rate       float64 //tokens added per second
+	burst      float64
+	tokens     float64
+	lastRefill time.Time
+}
+
+func newTokenBucket(rl RateLimit) *tokenBucket {
+	if rl.RPS <= 0 {
+		return &tokenBucket{unlimited: true}
+	}
+	burst := rl.Burst
+	if burst <= 0 {
+		burst = int(rl.RPS)
+		if burst == 0 {
+			burst = 1
+		}
+	}
+	return &tokenBucket{rate: rl.RPS, burst: float64(burst), tokens: float64(burst), lastRefill: time.Now()}
+}
+
+func (b *tokenBucket) allow() bool {
+	if b.unlimited {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.lastRefill).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.lastRefill = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *tokenBucket) available() float64 {
+	if b.unlimited {
+		return math.Inf(1)
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.tokens
+}
+
+// BreakerConfig configures circuitBreaker's trip/recovery behaviour.
+type BreakerConfig struct {
+	Window         int           //how many recent requests the rolling error rate considers
+	ErrorThreshold float64       //Closed -> Open once the rolling error rate exceeds this
+	Cooldown       time.Duration //how long Open blocks requests before probing again
+	ProbeN         int           //concurrent requests allowed through while HalfOpen
+}
+
+func (cfg BreakerConfig) withDefaults() BreakerConfig {
+	if cfg.Window <= 0 {
+		cfg.Window = 20
+	}
+	if cfg.ErrorThreshold <= 0 {
+		cfg.ErrorThreshold = 0.5
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 5 * time.Second
+	}
+	if cfg.ProbeN <= 0 {
+		cfg.ProbeN = 1
+	}
+	return cfg
+}
+
+// BreakerStats is a snapshot of a circuitBreaker's behaviour, returned by
+// Stats() so callers can observe it during large scans.
+type BreakerStats struct {
+	State         string
+	Tokens        float64
+	ErrorRate     float64
+	DwellClosed   time.Duration
+	DwellOpen     time.Duration
+	DwellHalfOpen time.Duration
+}
+
+// circuitBreaker is a three-state circuit breaker backed by a token-bucket
+// rate limiter. Its state is read and switched with atomics on the hot path,
+// its rolling results are guarded by a mutex, and the limiter caps the
+// request rate instead of just adding a smoothing delay.
+type circuitBreaker struct {
+	cfg    BreakerConfig
+	bucket *tokenBucket
+
+	state       int32 //breakerState
+	resultMutex sync.Mutex
+	results     []bool
+	resultsPos  int
+	resultsLen  int
+	openedAt    time.Time
+	probeActive int32
+
+	dwellMutex     sync.Mutex
+	stateEnteredAt time.Time
+	dwell          map[breakerState]time.Duration
+}
+
+// NewCircuitBreaker builds a circuitBreaker gating requests at rl and
+// tripping according to cfg.
+func NewCircuitBreaker(rl RateLimit, cfg BreakerConfig) *circuitBreaker {
+	cfg = cfg.withDefaults()
+	return &circuitBreaker{
+		cfg:            cfg,
+		bucket:         newTokenBucket(rl),
+		results:        make([]bool, cfg.Window),
+		stateEnteredAt: time.Now(),
+		dwell:          make(map[breakerState]time.Duration, 3),
+	}
+}
+
+func (cb *circuitBreaker) State() breakerState {
+	return breakerState(atomic.LoadInt32(&cb.state))
+}
+
+// Allow reports whether the caller may issue a request right now, and the
+// state that admitted it. Closed requests still need a rate-limit token;
+// Open requests are blocked until cfg.Cooldown elapses, at which point the
+// breaker moves to HalfOpen; HalfOpen allows at most cfg.ProbeN concurrent
+// probes through. Callers must pass the returned state to Record, since the
+// breaker can flip Closed<->HalfOpen between a request's Allow and Record
+// and Record needs to know which state actually admitted this request.
+func (cb *circuitBreaker) Allow() (bool, breakerState) {
+	switch cb.State() {
+	case BreakerOpen:
+		cb.resultMutex.Lock()
+		cooledDown := time.Since(cb.openedAt) >= cb.cfg.Cooldown
+		cb.resultMutex.Unlock()
+		if !cooledDown {
+			return false, BreakerOpen
+		}
+		if atomic.CompareAndSwapInt32(&cb.state, int32(BreakerOpen), int32(BreakerHalfOpen)) {
+			cb.transitioned(BreakerOpen)
+		}
+		return cb.Allow()
+	case BreakerHalfOpen:
+		if atomic.AddInt32(&cb.probeActive, 1) > int32(cb.cfg.ProbeN) {
+			atomic.AddInt32(&cb.probeActive, -1)
+			return false, BreakerHalfOpen
+		}
+		if cb.bucket.allow() {
+			return true, BreakerHalfOpen
+		}
+		atomic.AddInt32(&cb.probeActive, -1)
+		return false, BreakerHalfOpen
+	default:
+		return cb.bucket.allow(), BreakerClosed
+	}
+}
+
+// Record reports the outcome of a request previously admitted by Allow.
+// state must be the value Allow returned alongside true for that request -
+// re-reading the breaker's current state here would attribute the outcome to
+// whatever state the breaker happens to be in now, which can differ from the
+// state that actually admitted the request and let HalfOpen's probeActive
+// accounting drift.
+func (cb *circuitBreaker) Record(state breakerState, success bool) {
+	if state == BreakerHalfOpen {
+		atomic.AddInt32(&cb.probeActive, -1)
+		if success {
+			cb.toClosed()
+		} else {
+			cb.toOpen()
+		}
+		return
+	}
+
+	cb.resultMutex.Lock()
+	cb.results[cb.resultsPos] = success
+	cb.resultsPos = (cb.resultsPos + 1) % len(cb.results)
+	if cb.resultsLen < len(cb.results) {
+		cb.resultsLen++
+	}
+	full := cb.resultsLen >= cb.cfg.Window
+	errorRate := cb.errorRateLocked()
+	cb.resultMutex.Unlock()
+
+	if full && errorRate > cb.cfg.ErrorThreshold {
+		cb.toOpen()
+	}
+}
+
+func (cb *circuitBreaker) errorRateLocked() float64 {
+	if cb.resultsLen == 0 {
+		return 0
+	}
+	failures := 0
+	for i := 0; i < cb.resultsLen; i++ {
+		if !cb.results[i] {
+			failures++
+		}
+	}
+	return float64(failures) / float64(cb.resultsLen)
+}
+
+func (cb *circuitBreaker) toOpen() {
+	old := atomic.SwapInt32(&cb.state, int32(BreakerOpen))
+	if old == int32(BreakerOpen) {
+		return
+	}
+	cb.resultMutex.Lock()
+	cb.openedAt = time.Now()
+	cb.resultsLen = 0
+	cb.resultsPos = 0
+	cb.resultMutex.Unlock()
+	cb.transitioned(breakerState(old))
+}
+
+func (cb *circuitBreaker) toClosed() {
+	old := atomic.SwapInt32(&cb.state, int32(BreakerClosed))
+	if old == int32(BreakerClosed) {
+		return
+	}
+	cb.resultMutex.Lock()
+	cb.resultsLen = 0
+	cb.resultsPos = 0
+	cb.resultMutex.Unlock()
+	cb.transitioned(breakerState(old))
+}
+
+func (cb *circuitBreaker) transitioned(from breakerState) {
+	cb.dwellMutex.Lock()
+	defer cb.dwellMutex.Unlock()
+	now := time.Now()
+	cb.dwell[from] += now.Sub(cb.stateEnteredAt)
+	cb.stateEnteredAt = now
+}
+
+// Stats returns the breaker's current state, available tokens, rolling
+// error rate and per-state dwell times.
+func (cb *circuitBreaker) Stats() BreakerStats {
+	cb.resultMutex.Lock()
+	errorRate := cb.errorRateLocked()
+	cb.resultMutex.Unlock()
+
+	cb.dwellMutex.Lock()
+	dwell := map[breakerState]time.Duration{
+		BreakerClosed:   cb.dwell[BreakerClosed],
+		BreakerOpen:     cb.dwell[BreakerOpen],
+		BreakerHalfOpen: cb.dwell[BreakerHalfOpen],
+	}
+	current := cb.State()
+	dwell[current] += time.Since(cb.stateEnteredAt)
+	cb.dwellMutex.Unlock()
+
+	return BreakerStats{
+		State:         current.String(),
+		Tokens:        cb.bucket.available(),
+		ErrorRate:     errorRate,
+		DwellClosed:   dwell[BreakerClosed],
+		DwellOpen:     dwell[BreakerOpen],
+		DwellHalfOpen: dwell[BreakerHalfOpen],
+	}
+}
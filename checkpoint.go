@@ -0,0 +1,232 @@
+package ethutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// CheckpointStore persists and restores the progress of a resumable log scan.
+// Implementations must be safe for concurrent use.
+type CheckpointStore interface {
+	Save(ctx context.Context, key string, state []byte) error
+	Load(ctx context.Context, key string) ([]byte, error)
+}
+
+// MemoryCheckpointStore keeps checkpoints in process memory. It is mainly
+// useful for tests, or for scans that only need to survive a goroutine
+// restart rather than a process restart.
+type MemoryCheckpointStore struct {
+	mutex sync.Mutex
+	data  map[string][]byte
+}
+
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{data: make(map[string][]byte)}
+}
+
+func (s *MemoryCheckpointStore) Save(_ context.Context, key string, state []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	buf := make([]byte, len(state))
+	copy(buf, state)
+	s.data[key] = buf
+	return nil
+}
+
+func (s *MemoryCheckpointStore) Load(_ context.Context, key string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state, ok := s.data[key]
+	if !ok {
+		return nil, nil
+	}
+	buf := make([]byte, len(state))
+	copy(buf, state)
+	return buf, nil
+}
+
+// FileCheckpointStore persists checkpoints as one JSON file per key under
+// Dir, so a crashed process can resume a scan after restart.
+type FileCheckpointStore struct {
+	Dir string
+}
+
+func NewFileCheckpointStore(dir string) *FileCheckpointStore {
+	return &FileCheckpointStore{Dir: dir}
+}
+
+func (s *FileCheckpointStore) path(key string) string {
+	return filepath.Join(s.Dir, key+".checkpoint.json")
+}
+
+func (s *FileCheckpointStore) Save(_ context.Context, key string, state []byte) error {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return errors.Wrap(err, "create checkpoint dir")
+	}
+	tmp := s.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, state, 0o644); err != nil {
+		return errors.Wrap(err, "write checkpoint")
+	}
+	return os.Rename(tmp, s.path(key))
+}
+
+func (s *FileCheckpointStore) Load(_ context.Context, key string) ([]byte, error) {
+	state, err := os.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read checkpoint")
+	}
+	return state, nil
+}
+
+// scanCheckpoint is the JSON record persisted by GetEventResumable. Completed
+// and Logs are keyed by logsWork.id so a resumed scan can skip ranges that
+// already finished without losing the logs they already fetched.
+type scanCheckpoint struct {
+	LastToBlock int64                 `json:"lastToBlock"`
+	Completed   map[int32]bool        `json:"completed"`
+	Logs        map[int32][]types.Log `json:"logs"`
+}
+
+// ResumableError is returned by GetEventResumable when a scan stops before
+// every range has completed, whether due to a fatal error or a timeout.
+// Calling GetEventResumable again with the same key and store resumes from
+// the last persisted checkpoint instead of starting over.
+type ResumableError struct {
+	Key string
+	Err error
+}
+
+func (e *ResumableError) Error() string {
+	return fmt.Sprintf("scan %q did not complete, call GetEventResumable with the same key to resume: %v", e.Key, e.Err)
+}
+
+func (e *ResumableError) Unwrap() error {
+	return e.Err
+}
+
+// GetEventResumable behaves like GetEvent but checkpoints its progress to
+// store every flushInterval. If the scan fails or times out it returns a
+// *ResumableError; calling GetEventResumable again with the same key skips
+// every logsWork range that was already marked complete.
+func (c *ethClient) GetEventResumable(ctx context.Context, key string, store CheckpointStore, flushInterval time.Duration, timeout time.Duration, from int64, to int64, address []common.Address, topics [][]common.Hash) (stream *logsStream, err error) {
+	checkpoint, err := loadScanCheckpoint(ctx, store, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "load checkpoint")
+	}
+
+	info := newGlobalInfo(timeout, from, to, address, topics)
+	info.pool = c.pool
+	info.breaker = c.breaker
+
+	stopFlush := make(chan struct{})
+	var flushWG sync.WaitGroup
+	if flushInterval > 0 {
+		flushWG.Add(1)
+		go flushCheckpoint(ctx, info, store, key, flushInterval, stopFlush, &flushWG)
+	}
+
+	var i int32 = 0
+	for ; i < info.workNumber; i++ {
+		work := newLogsWork(info)
+		if checkpoint != nil && checkpoint.Completed[work.id] {
+			work.returnValue = checkpoint.Logs[work.id]
+			atomic.StoreInt32(&work.completed, 1)
+			info.group.Done()
+			continue
+		}
+		work.handler(c.client)
+	}
+	info.group.Wait()
+	close(stopFlush)
+	flushWG.Wait()
+
+	ok := atomic.CompareAndSwapInt32((*int32)(&info.state), 0, 1)
+	if !ok {
+		_ = persistScanCheckpoint(ctx, store, key, info)
+		return nil, &ResumableError{Key: key, Err: info.err}
+	}
+	logs := info.arrangeLogs()
+	finalizer(info)
+	if store != nil {
+		_ = store.Save(ctx, key, nil) //scan finished cleanly, drop the checkpoint
+	}
+	stream = &logsStream{
+		logs:      logs,
+		client:    c,
+		m:         sync.Mutex{},
+		group:     sync.WaitGroup{},
+		workMutex: sync.Mutex{},
+	}
+	return stream, nil
+}
+
+func flushCheckpoint(ctx context.Context, info *globalInfo, store CheckpointStore, key string, interval time.Duration, stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = persistScanCheckpoint(ctx, store, key, info)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func persistScanCheckpoint(ctx context.Context, store CheckpointStore, key string, info *globalInfo) error {
+	if store == nil {
+		return nil
+	}
+	checkpoint := scanCheckpoint{Completed: make(map[int32]bool), Logs: make(map[int32][]types.Log)}
+	info.queueMutex.Lock()
+	queue := make([]*logsWork, len(info.queue))
+	copy(queue, info.queue)
+	info.queueMutex.Unlock()
+	for _, work := range queue {
+		if work == nil || atomic.LoadInt32(&work.completed) != 1 {
+			continue
+		}
+		checkpoint.Completed[work.id] = true
+		checkpoint.Logs[work.id] = work.returnValue
+		if work.filter.ToBlock != nil && work.filter.ToBlock.Int64() > checkpoint.LastToBlock {
+			checkpoint.LastToBlock = work.filter.ToBlock.Int64()
+		}
+	}
+	state, err := json.Marshal(checkpoint)
+	if err != nil {
+		return errors.Wrap(err, "marshal checkpoint")
+	}
+	return store.Save(ctx, key, state)
+}
+
+func loadScanCheckpoint(ctx context.Context, store CheckpointStore, key string) (*scanCheckpoint, error) {
+	if store == nil {
+		return nil, nil
+	}
+	state, err := store.Load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(state) == 0 {
+		return nil, nil
+	}
+	checkpoint := &scanCheckpoint{}
+	if err := json.Unmarshal(state, checkpoint); err != nil {
+		return nil, errors.Wrap(err, "unmarshal checkpoint")
+	}
+	return checkpoint, nil
+}
@@ -0,0 +1,59 @@
+package ethutil
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// ethClient wraps a go-ethereum client with ethutil's concurrent log-scanning
+// helpers.
+type ethClient struct {
+	client *ethclient.Client
+	//pool, when set, is used instead of client for FilterLogs so a scan can
+	//fail over across multiple provider endpoints
+	pool *EthClientPool
+	//breaker gates and observes every scan issued by this client; shared
+	//across scans so WithBreaker's config and Stats() both apply client-wide
+	breaker *circuitBreaker
+}
+
+func NewEthClient(client *ethclient.Client) *ethClient {
+	return &ethClient{client: client, breaker: NewCircuitBreaker(RateLimit{}, BreakerConfig{})}
+}
+
+// NewEthClientFromPool builds an ethClient that spreads its log scans across
+// every endpoint in pool instead of talking to a single node.
+func NewEthClientFromPool(pool *EthClientPool) *ethClient {
+	return &ethClient{pool: pool, breaker: NewCircuitBreaker(RateLimit{}, BreakerConfig{})}
+}
+
+// WithBreaker replaces c's rate limiter/circuit breaker with one configured
+// from rl and cfg. Call it before starting a scan; the zero value of either
+// (the default from NewEthClient/NewEthClientFromPool) rate-limits at no cap
+// and trips only on BreakerConfig's default error-rate threshold.
+func (c *ethClient) WithBreaker(rl RateLimit, cfg BreakerConfig) *ethClient {
+	c.breaker = NewCircuitBreaker(rl, cfg)
+	return c
+}
+
+// Stats returns a snapshot of c's circuit breaker: its state, available rate
+// limit tokens, rolling error rate and per-state dwell times.
+func (c *ethClient) Stats() BreakerStats {
+	return c.breaker.Stats()
+}
+
+// logsStream holds the logs returned by a completed GetEvent/GetEventResumable
+// scan, plus the plumbing needed to launch further scans from the same client.
+type logsStream struct {
+	logs      []types.Log
+	client    *ethClient
+	m         sync.Mutex
+	group     sync.WaitGroup
+	workMutex sync.Mutex
+}
+
+func (s *logsStream) Logs() []types.Log {
+	return s.logs
+}
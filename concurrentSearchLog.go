@@ -9,7 +9,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"math/big"
-	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -36,29 +36,22 @@ const maxQueryBlockSize int64 = 2000
 const defaultMallocCap int64 = 1024
 const maxConcurrentNumber = 1e5
 const maxWorkNumber = maxConcurrentNumber / maxQueryBlockSize
-const emergencyRecovery = 100
-const smoothRecoverRatio = 0.25
-
-var defaultSmoothRecoverTimes = time.Millisecond * 2
 
 func (c *ethClient) GetCurrentBlockNumber() (uint64, error) {
 	return c.client.BlockNumber(context.Background())
 }
 
+// GetEvent is a thin wrapper around GetEventStream that drains the stream
+// into a single, fully materialized slice of logs.
 func (c *ethClient) GetEvent(timeout time.Duration, from int64, to int64, address []common.Address, topics [][]common.Hash) (stream *logsStream, err error) {
-	info := newGlobalInfo(timeout, from, to, address, topics)
-	var workNumber = info.workNumber
-	var i int32 = 0
-	for ; i < workNumber; i++ {
-		newLogsWork(info).handler(c.client)
+	batches, errs := c.GetEventStream(context.Background(), timeout, from, to, address, topics)
+	logs := make([]types.Log, 0, defaultMallocCap)
+	for batch := range batches {
+		logs = append(logs, batch.Logs...)
 	}
-	info.group.Wait()
-	ok := atomic.CompareAndSwapInt32((*int32)(&info.state), 0, 1)
-	if !ok {
-		return nil, fmt.Errorf("get event error: %v", info.err)
+	if streamErr := <-errs; streamErr != nil {
+		return nil, fmt.Errorf("get event error: %v", streamErr)
 	}
-	logs := info.arrangeLogs()
-	finalizer(info)
 	stream = &logsStream{
 		logs:      logs,
 		client:    c,
@@ -76,10 +69,20 @@ func finalizer(info *globalInfo) {
 	info = nil
 }
 func (g *globalInfo) arrangeLogs() []types.Log {
-	var i int32 = 0
+	g.queueMutex.Lock()
+	ordered := make([]*logsWork, len(g.queue))
+	copy(ordered, g.queue)
+	g.queueMutex.Unlock()
+	//adaptive splits append new work out of FromBlock order, so sort before concatenating
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].filter.FromBlock.Int64() < ordered[j].filter.FromBlock.Int64()
+	})
 	var result = make([]types.Log, 0, defaultMallocCap)
-	for ; i < g.currentId; i++ {
-		result = append(result, g.queue[i].returnValue...)
+	for _, work := range ordered {
+		if work == nil {
+			continue
+		}
+		result = append(result, work.returnValue...)
 	}
 	return result
 }
@@ -89,13 +92,11 @@ func newGlobalInfo(timeout time.Duration, from int64, to int64, address []common
 	if maxQueryBlockSize*workNumber+from != to {
 		workNumber++
 	}
-	g = &globalInfo{end: to, errTrigger: sync.Once{}, mutex: sync.Mutex{}, workNumber: int32(workNumber), address: address, topics: topics, offset: from, timeout: timeout, queue: make([]*logsWork, workNumber), group: sync.WaitGroup{}}
+	g = &globalInfo{end: to, errTrigger: sync.Once{}, mutex: sync.Mutex{}, queueMutex: sync.Mutex{}, workNumber: int32(workNumber), address: address, topics: topics, offset: from, timeout: timeout, queue: make([]*logsWork, workNumber), group: sync.WaitGroup{}}
 	var chanNumber = workNumber
 	if chanNumber > maxWorkNumber {
 		chanNumber = maxWorkNumber
 	}
-	g.workMutex = sync.Mutex{}
-	g.controlPanel = controlPanel{cond: sync.NewCond(&g.workMutex), recoverSignal: make(chan int32, 1)}
 	g.workChan = make(chan int8, chanNumber)
 	var i int64
 	for ; i < chanNumber; i++ {
@@ -119,11 +120,23 @@ type globalInfo struct {
 	mutex        sync.Mutex //err mutex
 	err          error
 	errTrigger   sync.Once
-	workMutex    sync.Mutex
 	workChan     chan int8
-	retryTimes   int32
-	controlPanel controlPanel
-	//smooth     int32
+	breaker      *circuitBreaker
+	//ctx, when set by GetEventStream, lets workers observe the caller's
+	//cancellation instead of running every claimed range to completion
+	//regardless; nil elsewhere, where callers have no cancellable context
+	ctx context.Context
+
+	//adaptive range sizing; zero value keeps the fixed maxQueryBlockSize behaviour above
+	adaptiveEnabled    bool
+	adaptive           AdaptiveRangeConfig
+	queueMutex         sync.Mutex //guards queue appends made by adaptive splits
+	nextBlock          int64      //next block not yet claimed by an adaptive work range
+	currentRangeSize   int64      //current adaptive allocation size, grows/shrinks with feedback
+	consecutiveSuccess int32
+
+	//pool, when set, is used instead of the single client handler receives
+	pool *EthClientPool
 }
 type logsWork struct {
 	id          int32
@@ -131,6 +144,34 @@ type logsWork struct {
 	shareInfo   *globalInfo
 	done        chan struct{}
 	filter      ethereum.FilterQuery
+	completed   int32 //1 once FilterLogs has returned successfully, checked by the checkpoint flusher
+	err         error //this work's own error, if any; distinct from shareInfo.err which is the aggregate
+
+	//streamDone, when set by GetEventStream, receives this work once it
+	//finishes, so the stream consumer can flush it in id order; the
+	//workChan slot is still released as soon as FilterLogs returns (see
+	//handler), independent of when the batch is actually flushed
+	streamDone chan *logsWork
+}
+
+// ctxErr reports g.ctx's error, or nil if g.ctx is unset or not yet done.
+func (g *globalInfo) ctxErr() error {
+	if g.ctx == nil {
+		return nil
+	}
+	return g.ctx.Err()
+}
+
+// release returns this work's workChan slot, allowing a new worker to start.
+func (work *logsWork) release() {
+	work.shareInfo.workChan <- 0
+}
+
+// notifyStream hands work to streamDone if GetEventStream is waiting on it.
+func (work *logsWork) notifyStream() {
+	if work.streamDone != nil {
+		work.streamDone <- work
+	}
 }
 
 func newLogsWork(global *globalInfo) (result *logsWork) {
@@ -150,53 +191,41 @@ func newLogsWork(global *globalInfo) (result *logsWork) {
 		filter:    ethereum.FilterQuery{Topics: global.topics, Addresses: global.address, FromBlock: big.NewInt(int64(id)*maxQueryBlockSize + global.offset), ToBlock: big.NewInt(end)},
 	}
 	result.done <- struct{}{}
+	global.queueMutex.Lock()
 	global.queue[id] = result
+	global.queueMutex.Unlock()
 	return result
 }
 
-type controlPanel struct {
-	cond          *sync.Cond
-	state         int32 //state 0: 正常 state 1: 熔断,平滑过度
-	failedTimes   int32
-	sumTimes      int32
-	recoverSignal chan int32
+// filterLogs runs work's filter against work.shareInfo.pool when one is
+// configured, falling back to the single client passed to handler otherwise.
+func (work *logsWork) filterLogs(client *ethclient.Client) ([]types.Log, error) {
+	return work.filterLogsWithFilter(client, work.filter)
 }
 
-func (cp *controlPanel) smoothRecover() {
-	fmt.Println("check control")
-	defer func() {
-		fmt.Println("recover")
-	}()
-	time.Sleep(defaultSmoothRecoverTimes)
-	var timeGap = time.NewTicker(defaultSmoothRecoverTimes)
-	for {
-		select {
-		case <-timeGap.C:
-			cp.cond.Signal()
-		case <-cp.recoverSignal:
-			cp.cond.Broadcast()
-			timeGap.Stop()
-			return
-		default:
-		}
+// filterLogsWithFilter is filterLogs for a caller-supplied filter instead of
+// work.filter, used by bloomHandler to fetch each narrowed-down span. It
+// honours work.shareInfo.ctx so cancelling a GetEventStream caller's context
+// actually aborts in-flight requests, not just future ones.
+func (work *logsWork) filterLogsWithFilter(client *ethclient.Client, filter ethereum.FilterQuery) ([]types.Log, error) {
+	ctx := work.shareInfo.ctx
+	if ctx == nil {
+		ctx = context.Background()
 	}
-}
-func (cp *controlPanel) recover() {
-	atomic.CompareAndSwapInt32(&cp.state, 1, 0)
-	cp.sumTimes = 0
-	cp.failedTimes = 0
-	cp.recoverSignal <- 1
+	if work.shareInfo.pool != nil {
+		return work.shareInfo.pool.Do(ctx, filter)
+	}
+	return client.FilterLogs(ctx, filter)
 }
 
 func (work *logsWork) handler(client *ethclient.Client) {
 	go func() {
 		<-work.shareInfo.workChan
-		defer func() {
-			work.shareInfo.workChan <- 0
-		}()
+		defer work.release()
 		defer work.shareInfo.group.Done()
 		state := atomic.LoadInt32((*int32)(&work.shareInfo.state))
 		if state == 2 || state == 3 {
+			work.notifyStream()
 			return
 		}
 		//timer
@@ -205,34 +234,28 @@ func (work *logsWork) handler(client *ethclient.Client) {
 			select {
 			case <-work.done:
 			retryGet:
-				work.shareInfo.workMutex.Lock()
-				//平滑过度
-				if atomic.LoadInt32(&work.shareInfo.controlPanel.state) != 0 {
-					work.shareInfo.controlPanel.cond.Wait()
+				if ctxErr := work.shareInfo.ctxErr(); ctxErr != nil {
+					work.err = ctxErr
+					work.notifyStream()
+					return
 				}
-				work.shareInfo.workMutex.Unlock()
-				if atomic.LoadInt32(&work.shareInfo.controlPanel.state) == 1 {
-					atomic.AddInt32(&work.shareInfo.controlPanel.sumTimes, 1)
-					if atomic.LoadInt32(&work.shareInfo.controlPanel.sumTimes) == 0 || float64(work.shareInfo.controlPanel.failedTimes/work.shareInfo.controlPanel.sumTimes) < smoothRecoverRatio {
-						work.shareInfo.controlPanel.recover()
+				var admittedBy breakerState
+				for {
+					var ok bool
+					if ok, admittedBy = work.shareInfo.breaker.Allow(); ok {
+						break
 					}
+					if ctxErr := work.shareInfo.ctxErr(); ctxErr != nil {
+						work.err = ctxErr
+						work.notifyStream()
+						return
+					}
+					time.Sleep(breakerPollInterval)
 				}
-				logs, err := client.FilterLogs(context.Background(), work.filter)
+				logs, err := work.filterLogs(client)
+				work.shareInfo.breaker.Record(admittedBy, err == nil)
 				if err != nil {
-					if work.shareInfo.retryTimes >= emergencyRecovery {
-						if atomic.CompareAndSwapInt32(&work.shareInfo.controlPanel.state, 0, 1) {
-							fmt.Println(work.shareInfo.retryTimes)
-							work.shareInfo.retryTimes = 0
-							work.shareInfo.controlPanel.smoothRecover()
-						}
-					}
-					if strings.Contains(err.Error(), "429 Too Many Requests") {
-						if atomic.LoadInt32(&work.shareInfo.controlPanel.state) == 0 {
-							atomic.AddInt32(&work.shareInfo.retryTimes, 1)
-							time.Sleep(defaultSmoothRecoverTimes)
-						} else if atomic.LoadInt32(&work.shareInfo.controlPanel.state) == 1 {
-							atomic.AddInt32(&work.shareInfo.controlPanel.failedTimes, 1)
-						}
+					if strings.Contains(err.Error(), "429 Too Many Requests") || errors.Is(err, ErrAllEndpointsCooling) {
 						goto retryGet
 					}
 					//atomic.SwapInt32((*int32)(&work.state), 2)
@@ -247,10 +270,14 @@ func (work *logsWork) handler(client *ethclient.Client) {
 						work.shareInfo.err = fmt.Errorf("%v \n %v", work.shareInfo.err, err)
 					}
 					work.shareInfo.mutex.Unlock()
+					work.err = err
+					work.notifyStream()
 					return
 				}
 				//atomic.SwapInt32((*int32)(&work.state), 1)
 				work.returnValue = logs
+				atomic.StoreInt32(&work.completed, 1)
+				work.notifyStream()
 				return
 			case <-timer.C:
 				//_ = atomic.CompareAndSwapInt32((*int32)(&work.state), 0, 3)
@@ -260,11 +287,14 @@ func (work *logsWork) handler(client *ethclient.Client) {
 					work.shareInfo.err = errors.New("From %s block to %s block search timeout error")
 				}
 				work.shareInfo.mutex.Unlock()
+				work.err = work.shareInfo.err
+				work.notifyStream()
 				return
 			//monitor the global state ,in order to exit in error
 			default:
 				state = atomic.LoadInt32((*int32)(&work.shareInfo.state))
 				if state == 2 || state == 3 {
+					work.notifyStream()
 					return
 				}
 			}
@@ -0,0 +1,289 @@
+package ethutil
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const defaultFollowPollInterval = 4 * time.Second
+const defaultReorgDepth = 64
+
+// FollowOptions configures Follow's behaviour once the initial backfill has
+// caught up to the chain head.
+type FollowOptions struct {
+	Confirmations      uint64
+	PollInterval       time.Duration
+	ReorgDepth         int
+	PreferSubscription bool
+}
+
+func (o FollowOptions) withDefaults() FollowOptions {
+	if o.PollInterval <= 0 {
+		o.PollInterval = defaultFollowPollInterval
+	}
+	if o.ReorgDepth <= 0 {
+		o.ReorgDepth = defaultReorgDepth
+	}
+	return o
+}
+
+// Reorg reports that the chain reorganized somewhere in [FromBlock, ToBlock];
+// consumers should treat any logs they received in that range as invalidated.
+type Reorg struct {
+	FromBlock uint64
+	ToBlock   uint64
+}
+
+type blockRef struct {
+	Number uint64
+	Hash   common.Hash
+}
+
+// blockRing is a fixed-size ring buffer of the most recently seen block
+// number/hash pairs, used to detect reorgs while polling.
+type blockRing struct {
+	buf  []blockRef
+	pos  int
+	full bool
+}
+
+func newBlockRing(depth int) *blockRing {
+	return &blockRing{buf: make([]blockRef, depth)}
+}
+
+func (r *blockRing) push(ref blockRef) {
+	r.buf[r.pos] = ref
+	r.pos = (r.pos + 1) % len(r.buf)
+	if r.pos == 0 {
+		r.full = true
+	}
+}
+
+func (r *blockRing) find(number uint64) (blockRef, bool) {
+	n := len(r.buf)
+	limit := r.pos
+	if r.full {
+		limit = n
+	}
+	for i := 0; i < limit; i++ {
+		idx := (r.pos - 1 - i + n) % n
+		if r.buf[idx].Hash != (common.Hash{}) && r.buf[idx].Number == number {
+			return r.buf[idx], true
+		}
+	}
+	return blockRef{}, false
+}
+
+// Follow backfills [from, head-Confirmations] the same way GetEvent does,
+// then switches to tailing new blocks as they arrive: over an
+// eth_subscribe("logs", ...) websocket subscription when the underlying
+// client supports it and opts.PreferSubscription is set, or by polling
+// BlockNumber+FilterLogs otherwise. While polling, a ring buffer of the last
+// opts.ReorgDepth {number, hash} pairs is used to detect reorgs on every new
+// head; on divergence, Follow emits a Reorg and re-scans the affected range
+// so consumers can invalidate previously emitted logs.
+func (c *ethClient) Follow(ctx context.Context, from int64, addrs []common.Address, topics [][]common.Hash, opts FollowOptions) (<-chan LogBatch, <-chan Reorg, <-chan error) {
+	opts = opts.withDefaults()
+	batches := make(chan LogBatch)
+	reorgs := make(chan Reorg)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(batches)
+		defer close(reorgs)
+		defer close(errs)
+
+		head, err := c.GetCurrentBlockNumber()
+		if err != nil {
+			errs <- fmt.Errorf("follow: get head: %w", err)
+			return
+		}
+		caughtUpTo := int64(head) - int64(opts.Confirmations)
+		if caughtUpTo >= from {
+			if err := c.backfillRange(ctx, from, caughtUpTo, addrs, topics, batches); err != nil {
+				errs <- err
+				return
+			}
+			from = caughtUpTo + 1
+		}
+
+		ring := newBlockRing(opts.ReorgDepth)
+
+		if opts.PreferSubscription {
+			if sub, logsCh, ok := c.trySubscribeLogs(ctx, addrs, topics); ok {
+				// The subscription only delivers logs from blocks mined after
+				// it's established, so close the gap between from and the
+				// current head with a backfill before tailing it - otherwise
+				// every log in that window, including the whole confirmations
+				// window just caught up past, would be silently dropped.
+				gapHead, err := c.GetCurrentBlockNumber()
+				if err != nil {
+					sub.Unsubscribe()
+					errs <- fmt.Errorf("follow: get head for subscription gap-fill: %w", err)
+					return
+				}
+				if int64(gapHead) >= from {
+					if err := c.backfillRange(ctx, from, int64(gapHead), addrs, topics, batches); err != nil {
+						sub.Unsubscribe()
+						errs <- err
+						return
+					}
+				}
+				c.tailSubscription(ctx, sub, logsCh, batches, reorgs, errs)
+				return
+			}
+		}
+		c.tailPolling(ctx, from, addrs, topics, opts, ring, batches, reorgs, errs)
+	}()
+	return batches, reorgs, errs
+}
+
+// backfillRange streams [from, to] via GetEventStream, forwarding every batch
+// to batches in order. It returns the stream's terminal error, if any,
+// including ctx cancellation while waiting for a batch to be accepted.
+func (c *ethClient) backfillRange(ctx context.Context, from int64, to int64, addrs []common.Address, topics [][]common.Hash, batches chan<- LogBatch) error {
+	backfill, backfillErrs := c.GetEventStream(ctx, time.Duration(TimeLess), from, to, addrs, topics)
+	for batch := range backfill {
+		select {
+		case batches <- batch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return <-backfillErrs
+}
+
+// trySubscribeLogs attempts an eth_subscribe("logs", ...) subscription; it
+// reports ok=false rather than an error so Follow can fall back to polling,
+// since the most common cause is simply an HTTP-only client.
+func (c *ethClient) trySubscribeLogs(ctx context.Context, addrs []common.Address, topics [][]common.Hash) (ethereum.Subscription, chan types.Log, bool) {
+	logsCh := make(chan types.Log)
+	sub, err := c.client.SubscribeFilterLogs(ctx, ethereum.FilterQuery{Addresses: addrs, Topics: topics}, logsCh)
+	if err != nil {
+		return nil, nil, false
+	}
+	return sub, logsCh, true
+}
+
+func (c *ethClient) tailSubscription(ctx context.Context, sub ethereum.Subscription, logsCh chan types.Log, batches chan<- LogBatch, reorgs chan<- Reorg, errs chan<- error) {
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			errs <- ctx.Err()
+			return
+		case err := <-sub.Err():
+			errs <- fmt.Errorf("follow: subscription error: %w", err)
+			return
+		case log := <-logsCh:
+			//the node itself marks logs from orphaned blocks as removed
+			if log.Removed {
+				reorgs <- Reorg{FromBlock: log.BlockNumber, ToBlock: log.BlockNumber}
+				continue
+			}
+			select {
+			case batches <- LogBatch{FromBlock: log.BlockNumber, ToBlock: log.BlockNumber, Logs: []types.Log{log}}:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+		}
+	}
+}
+
+func (c *ethClient) tailPolling(ctx context.Context, from int64, addrs []common.Address, topics [][]common.Hash, opts FollowOptions, ring *blockRing, batches chan<- LogBatch, reorgs chan<- Reorg, errs chan<- error) {
+	ticker := time.NewTicker(opts.PollInterval)
+	defer ticker.Stop()
+	next := uint64(from)
+	for {
+		select {
+		case <-ctx.Done():
+			errs <- ctx.Err()
+			return
+		case <-ticker.C:
+			head, err := c.GetCurrentBlockNumber()
+			if err != nil {
+				errs <- fmt.Errorf("follow: poll head: %w", err)
+				return
+			}
+			if head < opts.Confirmations {
+				continue
+			}
+			safeHead := head - opts.Confirmations
+			if safeHead < next {
+				continue
+			}
+
+			if reorgFrom, ok := c.detectReorg(ctx, ring, next); ok {
+				reorgs <- Reorg{FromBlock: reorgFrom, ToBlock: next - 1}
+				next = reorgFrom
+			}
+
+			logs, err := c.client.FilterLogs(ctx, ethereum.FilterQuery{
+				FromBlock: big.NewInt(int64(next)),
+				ToBlock:   big.NewInt(int64(safeHead)),
+				Addresses: addrs,
+				Topics:    topics,
+			})
+			if err != nil {
+				errs <- fmt.Errorf("follow: poll filter logs: %w", err)
+				return
+			}
+			for n := next; n <= safeHead; n++ {
+				header, err := c.client.HeaderByNumber(ctx, big.NewInt(int64(n)))
+				if err != nil {
+					errs <- fmt.Errorf("follow: fetch header %d: %w", n, err)
+					return
+				}
+				ring.push(blockRef{Number: n, Hash: header.Hash()})
+			}
+			select {
+			case batches <- LogBatch{FromBlock: next, ToBlock: safeHead, Logs: logs}:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+			next = safeHead + 1
+		}
+	}
+}
+
+// detectReorg walks the ring buffer back from next-1 looking for the first
+// block whose stored hash still matches the chain, and reports the first
+// block past that point as the start of the affected range.
+func (c *ethClient) detectReorg(ctx context.Context, ring *blockRing, next uint64) (uint64, bool) {
+	if next == 0 {
+		return 0, false
+	}
+	last, ok := ring.find(next - 1)
+	if !ok {
+		return 0, false
+	}
+	header, err := c.client.HeaderByNumber(ctx, big.NewInt(int64(next-1)))
+	if err != nil || header.Hash() == last.Hash {
+		return 0, false
+	}
+	for n, walked := next-1, 0; ; walked++ {
+		if n == 0 || walked >= len(ring.buf) {
+			return n, true
+		}
+		n--
+		candidate, ok := ring.find(n)
+		if !ok {
+			return n + 1, true
+		}
+		h, err := c.client.HeaderByNumber(ctx, big.NewInt(int64(n)))
+		if err != nil {
+			return n + 1, true
+		}
+		if h.Hash() == candidate.Hash {
+			return n + 1, true
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package ethutil
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// PoolPolicy selects which endpoint EthClientPool.Do picks for the next request.
+type PoolPolicy int32
+
+const (
+	RoundRobin PoolPolicy = iota
+	LeastOutstanding
+	LatencyWeighted
+)
+
+const defaultEndpointCooldown = 5 * time.Second
+const latencyEWMAAlpha = 0.2
+
+// ErrAllEndpointsCooling is returned by EthClientPool.Do when every endpoint
+// in the pool is currently cooling down from a failure, so the caller's
+// circuit breaker should trip exactly as if it had a single failing endpoint.
+var ErrAllEndpointsCooling = errors.New("ethutil: all pool endpoints are cooling down")
+
+var retryableEndpointPatterns = []string{
+	"429", "too many requests",
+	"timeout", "connection refused", "eof",
+	"502", "503", "504",
+}
+
+func isRetryableEndpointError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, pattern := range retryableEndpointPatterns {
+		if strings.Contains(msg, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+type poolEndpoint struct {
+	url       string
+	client    *ethclient.Client
+	inFlight  int32
+	latencyMs int64 //EWMA latency in milliseconds
+	coolUntil int64 //unix nano; zero or past means the endpoint is healthy
+}
+
+// EthClientPool is a set of endpoints for the same chain that a scan can
+// spread load across, failing over to a healthy endpoint instead of
+// escalating into the scan's circuit breaker the moment one provider
+// misbehaves.
+type EthClientPool struct {
+	endpoints []*poolEndpoint
+	policy    PoolPolicy
+	next      int32 //round-robin cursor
+	cooldown  time.Duration
+}
+
+// NewEthClientPool dials every endpoint and returns a pool that distributes
+// FilterLogs calls across them according to policy.
+func NewEthClientPool(endpoints []string, policy PoolPolicy) (*EthClientPool, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("ethutil: client pool needs at least one endpoint")
+	}
+	pool := &EthClientPool{policy: policy, cooldown: defaultEndpointCooldown}
+	for _, url := range endpoints {
+		client, err := ethclient.Dial(url)
+		if err != nil {
+			return nil, errors.Wrapf(err, "dial endpoint %q", url)
+		}
+		pool.endpoints = append(pool.endpoints, &poolEndpoint{url: url, client: client})
+	}
+	return pool, nil
+}
+
+func (p *EthClientPool) isCooling(ep *poolEndpoint) bool {
+	return atomic.LoadInt64(&ep.coolUntil) > time.Now().UnixNano()
+}
+
+func (p *EthClientPool) cool(ep *poolEndpoint) {
+	atomic.StoreInt64(&ep.coolUntil, time.Now().Add(p.cooldown).UnixNano())
+}
+
+func (p *EthClientPool) pick() *poolEndpoint {
+	switch p.policy {
+	case LeastOutstanding:
+		return p.pickLeastOutstanding()
+	case LatencyWeighted:
+		return p.pickLatencyWeighted()
+	default:
+		return p.pickRoundRobin()
+	}
+}
+
+func (p *EthClientPool) pickRoundRobin() *poolEndpoint {
+	n := int32(len(p.endpoints))
+	for i := int32(0); i < n; i++ {
+		idx := atomic.AddInt32(&p.next, 1) % n
+		if idx < 0 {
+			idx += n
+		}
+		if ep := p.endpoints[idx]; !p.isCooling(ep) {
+			return ep
+		}
+	}
+	return nil
+}
+
+func (p *EthClientPool) pickLeastOutstanding() *poolEndpoint {
+	var best *poolEndpoint
+	for _, ep := range p.endpoints {
+		if p.isCooling(ep) {
+			continue
+		}
+		if best == nil || atomic.LoadInt32(&ep.inFlight) < atomic.LoadInt32(&best.inFlight) {
+			best = ep
+		}
+	}
+	return best
+}
+
+func (p *EthClientPool) pickLatencyWeighted() *poolEndpoint {
+	var best *poolEndpoint
+	for _, ep := range p.endpoints {
+		if p.isCooling(ep) {
+			continue
+		}
+		if best == nil || atomic.LoadInt64(&ep.latencyMs) < atomic.LoadInt64(&best.latencyMs) {
+			best = ep
+		}
+	}
+	return best
+}
+
+func (p *EthClientPool) recordLatency(ep *poolEndpoint, sampleMs int64) {
+	for {
+		old := atomic.LoadInt64(&ep.latencyMs)
+		next := sampleMs
+		if old != 0 {
+			next = int64(latencyEWMAAlpha*float64(sampleMs) + (1-latencyEWMAAlpha)*float64(old))
+		}
+		if atomic.CompareAndSwapInt64(&ep.latencyMs, old, next) {
+			return
+		}
+	}
+}
+
+// Do runs filter against the healthiest endpoint picked by p.policy, retrying
+// on a different endpoint when the current one's error mentions 429, 502, 503,
+// 504, a timeout, a refused connection or EOF; any other error, including
+// other 5xx statuses, is returned as is. It only returns ErrAllEndpointsCooling once every endpoint in the
+// pool is cooling down, so callers can treat that as the single failure
+// signal their circuit breaker already understands.
+func (p *EthClientPool) Do(ctx context.Context, filter ethereum.FilterQuery) ([]types.Log, error) {
+	var lastErr error
+	for attempt := 0; attempt < len(p.endpoints); attempt++ {
+		ep := p.pick()
+		if ep == nil {
+			return nil, ErrAllEndpointsCooling
+		}
+		atomic.AddInt32(&ep.inFlight, 1)
+		start := time.Now()
+		logs, err := ep.client.FilterLogs(ctx, filter)
+		p.recordLatency(ep, time.Since(start).Milliseconds())
+		atomic.AddInt32(&ep.inFlight, -1)
+		if err == nil {
+			return logs, nil
+		}
+		if !isRetryableEndpointError(err) {
+			return nil, err
+		}
+		p.cool(ep)
+		lastErr = err
+	}
+	if lastErr == nil {
+		return nil, ErrAllEndpointsCooling
+	}
+	return nil, ErrAllEndpointsCooling
+}
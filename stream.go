@@ -0,0 +1,112 @@
+package ethutil
+
+import (
+	"container/heap"
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// LogBatch is one logsWork range's worth of logs.
+type LogBatch struct {
+	FromBlock uint64
+	ToBlock   uint64
+	Logs      []types.Log
+}
+
+// logsWorkHeap orders logsWork by id so GetEventStream can flush completions
+// in ascending block order even though workers finish out of order.
+type logsWorkHeap []*logsWork
+
+func (h logsWorkHeap) Len() int            { return len(h) }
+func (h logsWorkHeap) Less(i, j int) bool  { return h[i].id < h[j].id }
+func (h logsWorkHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *logsWorkHeap) Push(x interface{}) { *h = append(*h, x.(*logsWork)) }
+func (h *logsWorkHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// GetEventStream runs the same parallel, 2000-block-chunked scan as GetEvent
+// but emits a LogBatch on the returned channel as soon as each logsWork range
+// completes, instead of waiting for the whole scan and materializing every
+// log up front. Out-of-order completions are buffered in a min-heap keyed by
+// logsWork.id and flushed as soon as the prefix contiguous from the last
+// emitted id is available, so batches always arrive in ascending block order.
+//
+// Each worker releases its workChan slot as soon as FilterLogs returns, same
+// as GetEvent - flush order only controls the order batches are sent on the
+// returned channel, it does not gate dispatch of further workers, so a slow
+// consumer can't stall every worker behind a handful of workChan slots.
+func (c *ethClient) GetEventStream(ctx context.Context, timeout time.Duration, from int64, to int64, address []common.Address, topics [][]common.Hash) (<-chan LogBatch, <-chan error) {
+	batches := make(chan LogBatch)
+	errs := make(chan error, 1)
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	info := newGlobalInfo(timeout, from, to, address, topics)
+	info.pool = c.pool
+	info.breaker = c.breaker
+	info.ctx = ctx
+
+	done := make(chan *logsWork, int(info.workNumber))
+	var i int32 = 0
+	for ; i < info.workNumber; i++ {
+		work := newLogsWork(info)
+		work.streamDone = done
+		work.handler(c.client)
+	}
+
+	go func() {
+		defer close(batches)
+		defer close(errs)
+		// cancel tells any worker still mid-range to stop, and group.Wait
+		// blocks until they actually have - only then is it safe for
+		// finalizer to nil out their shareInfo pointers.
+		defer func() {
+			info.group.Wait()
+			finalizer(info)
+		}()
+		defer cancel()
+
+		pending := &logsWorkHeap{}
+		heap.Init(pending)
+		var nextID int32 = 0
+		remaining := info.workNumber
+
+		for remaining > 0 {
+			select {
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			case work := <-done:
+				remaining--
+				heap.Push(pending, work)
+				for pending.Len() > 0 && (*pending)[0].id == nextID {
+					next := heap.Pop(pending).(*logsWork)
+					if next.err != nil {
+						errs <- next.err
+						return
+					}
+					select {
+					case batches <- LogBatch{
+						FromBlock: next.filter.FromBlock.Uint64(),
+						ToBlock:   next.filter.ToBlock.Uint64(),
+						Logs:      next.returnValue,
+					}:
+					case <-ctx.Done():
+						errs <- ctx.Err()
+						return
+					}
+					nextID++
+				}
+			}
+		}
+	}()
+	return batches, errs
+}